@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+var (
+	cacheDir string
+	force    bool
+	runs     int
+)
+
+func init() {
+	flag.StringVar(&cacheDir, "cache", "", "directory to cache benchmark results in, keyed by the commit's full hash. if set, reuses existing results instead of re-running benchmarks")
+	flag.BoolVar(&force, "force", false, "ignore cached results and re-run the benchmarks even if a valid cache entry exists")
+	flag.IntVar(&runs, "runs", 0, "accumulate this many additional runs into a cached file, on top of whatever is already cached")
+}
+
+// manifest describes the environment a cached benchmark result was produced
+// in, so entries from an incompatible machine or flag set don't get silently
+// reused as if they were comparable.
+type manifest struct {
+	GoVersion string
+	GOOS      string
+	GOARCH    string
+	CPU       string
+	BenchCmd  []string
+}
+
+// currentManifest describes the environment benchCommit is about to run in.
+func currentManifest() manifest {
+	return manifest{
+		GoVersion: goVersion(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		CPU:       cpuModel(),
+		BenchCmd:  benchcmd,
+	}
+}
+
+func goVersion() string {
+	out, err := runCommandOutput(quickTimeout, "", "go", "version")
+	check("cannot get go version:", err)
+	return strings.TrimSpace(string(out))
+}
+
+// cpuModel makes a best-effort attempt at identifying the CPU model, for
+// inclusion in the cache manifest. Returns "" if it can't be determined.
+func cpuModel() string {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "model name") {
+			return strings.TrimSpace(strings.TrimPrefix(line[strings.Index(line, ":")+1:], " "))
+		}
+	}
+	return ""
+}
+
+// cachePaths returns the result file and manifest file a commit's cache
+// entry would live at, keyed by its full commit hash.
+func cachePaths(commitish string) (resultFile, manifestFile string) {
+	hash := strings.TrimSpace(getCommitHash(commitish))
+	return filepath.Join(cacheDir, hash+".txt"), filepath.Join(cacheDir, hash+".json")
+}
+
+// loadManifest reads and parses a cache entry's manifest, returning ok=false
+// if it doesn't exist or can't be parsed.
+func loadManifest(manifestFile string) (m manifest, ok bool) {
+	data, err := ioutil.ReadFile(manifestFile)
+	if err != nil {
+		return manifest{}, false
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		log.Println("ignoring unparseable manifest:", manifestFile, ":", err)
+		return manifest{}, false
+	}
+	return m, true
+}
+
+func saveManifest(manifestFile string, m manifest) {
+	data, err := json.MarshalIndent(m, "", "\t")
+	check("cannot marshal cache manifest:", err)
+	check("cannot write cache manifest", manifestFile, ":", ioutil.WriteFile(manifestFile, data, 0666))
+}
+
+// cacheValid reports whether a cache entry's manifest matches the current
+// environment: same Go version, OS/arch, CPU, and benchmark flags. A mismatch
+// means the cached numbers aren't comparable to a fresh run, so the entry
+// must be invalidated rather than reused or appended to.
+func cacheValid(manifestFile string) bool {
+	want := currentManifest()
+	got, ok := loadManifest(manifestFile)
+	if !ok {
+		return false
+	}
+	return reflect.DeepEqual(got, want)
+}
+
+// benchCommitCached behaves like benchCommit, but first consults the cache
+// directory: a valid cached result is reused outright, `-runs` accumulates
+// additional runs into it, and `-force` or an environment mismatch triggers a
+// full re-run that overwrites the entry.
+func benchCommitCached(commitish, workdir string) string {
+	if cacheDir == "" {
+		return benchCommit(commitish, workdir)
+	}
+	if err := os.MkdirAll(cacheDir, 0777); err != nil {
+		check("cannot create cache directory", cacheDir, ":", err)
+	}
+	resultFile, manifestFile := cachePaths(commitish)
+	valid := !force && cacheValid(manifestFile)
+	if valid && runs <= 0 {
+		log.Println("using cached result for", commitish, ":", resultFile)
+		return resultFile
+	}
+	if valid && runs > 0 {
+		log.Println("appending", runs, "runs to cached result for", commitish, ":", resultFile)
+		appendBenchRuns(commitish, workdir, resultFile, runs)
+		return resultFile
+	}
+	log.Println("no valid cache entry for", commitish, ", running fresh benchmarks")
+	runFreshBench(commitish, workdir, resultFile)
+	saveManifest(manifestFile, currentManifest())
+	return resultFile
+}
+
+// runFreshBench runs the benchmark inside workdir and writes a fresh result
+// over any existing cache entry.
+func runFreshBench(commitish, workdir, resultFile string) {
+	file, err := os.OpenFile(resultFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	check("cannot create cache file", resultFile, ":", err)
+	defer func() { check("error closing bench output file", resultFile, ":", file.Close()) }()
+	err = runCommand(timeout, workdir, file, benchcmd[0], benchcmd[1:]...)
+	check("cannot run benchmarks for", commitish, ":", err)
+}
+
+// appendBenchRuns runs n additional benchmark runs inside workdir and
+// appends them to an existing cache file.
+func appendBenchRuns(commitish, workdir, resultFile string, n int) {
+	file, err := os.OpenFile(resultFile, os.O_RDWR|os.O_APPEND, 0666)
+	check("cannot open cache file", resultFile, ":", err)
+	defer func() { check("error closing bench output file", resultFile, ":", file.Close()) }()
+	cmd := append([]string{}, benchcmd...)
+	cmd = append(cmd, fmt.Sprintf("-test.count=%d", n))
+	err = runCommand(timeout, workdir, file, cmd[0], cmd[1:]...)
+	check("cannot run benchmarks for", commitish, ":", err)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+var (
+	timeout time.Duration
+	dryRun  bool
+
+	runCtx    context.Context
+	cancelRun context.CancelFunc
+)
+
+// quickTimeout bounds git and other metadata commands, which should never
+// run long; only the benchmark and comparison-tool invocations are expected
+// to take a while, and those are bounded by -timeout instead.
+const quickTimeout = 2 * time.Minute
+
+func init() {
+	flag.DurationVar(&timeout, "timeout", 30*time.Minute, "timeout for each benchmark and comparison-tool run; git commands use a shorter fixed timeout")
+	flag.BoolVar(&dryRun, "dry-run", false, "print commands instead of executing them")
+	runCtx, cancelRun = context.WithCancel(context.Background())
+}
+
+// runCommand runs name(args...) in dir (the current directory if empty),
+// writing its stdout to out (discarded if nil) and stderr to the log, bounded
+// by d and by runCtx. Every exec.Cmd call site in this program goes through
+// here or through runCommandOutput, so they all get the same logging,
+// timeout, and cancellation behavior instead of rolling their own; only
+// runCommand honors -dry-run.
+//
+// Canceling runCtx (SIGINT does this, see handleInterrupt) asks the child to
+// exit via SIGTERM; if it hasn't exited after cmd.WaitDelay, exec escalates
+// to SIGKILL itself.
+func runCommand(d time.Duration, dir string, out io.Writer, name string, args ...string) error {
+	if dryRun {
+		// Printed directly to stderr rather than logged: log output is
+		// discarded unless -verbose is also set, which would make -dry-run
+		// on its own produce no visible indication of what would run.
+		fmt.Fprintln(os.Stderr, "dry-run, not running:", append([]string{name}, args...), "in", dir)
+		return nil
+	}
+	return execWithContext(d, dir, out, name, args...)
+}
+
+// runCommandOutput is runCommand for callers that need the child's stdout
+// back as a value (a commit hash, a rev-list, a go version string) rather
+// than streamed to a file. These always execute even in -dry-run mode, since
+// their output is needed to decide what to do next.
+func runCommandOutput(d time.Duration, dir string, name string, args ...string) ([]byte, error) {
+	var buf bytes.Buffer
+	err := execWithContext(d, dir, &buf, name, args...)
+	return buf.Bytes(), err
+}
+
+// execWithContext is the part of runCommand that actually execs: apply the
+// timeout, run under runCtx so a cancellation (SIGINT) reaches the child,
+// and log argv, duration, and outcome.
+func execWithContext(d time.Duration, dir string, out io.Writer, name string, args ...string) error {
+	log.Println("running:", append([]string{name}, args...), "in", dir)
+	ctx, cancel := context.WithTimeout(runCtx, d)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.Stdout = out
+	cmd.Stderr = log.Writer()
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+	cmd.WaitDelay = 10 * time.Second
+	start := time.Now()
+	err := cmd.Run()
+	log.Println("finished:", name, "after", time.Since(start), "err:", err)
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s timed out after %s", name, d)
+	}
+	return err
+}
@@ -9,64 +9,129 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
-	"strings"
 )
 
 var (
-	commits    []string
-	outdir     string
-	currentcmd *exec.Cmd
+	commits []string
+	outdir  string
+	verbose bool
+
+	count      int
+	tool       string
+	interleave bool
 
 	benchcmd   = []string{"go", "test"}
 	comparecmd = []string{"benchcmp"}
 )
 
-// setup & parse flags, check command availability, build commands from flags
+// register this file's flags. Every *.go file in the package registers its
+// own flags from its own init(), since init() order across files depends on
+// filename and must not be relied on. flag.Parse() and everything that reads
+// a flag's value therefore happens in parseFlags, called from main() once
+// every init() (in every file) is guaranteed to have run.
 func init() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <commit-ish>...\nFlags:\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 	flag.StringVar(&outdir, "outdir", "", "directory to store benchmark results. if blank, uses the OS's temp dir and is cleaned up afterwards")
-	verbose := flag.Bool("verbose", false, "chatty logging")
+	flag.BoolVar(&verbose, "verbose", false, "chatty logging")
 	flag.String("test.run", "NONE", "run only the tests and examples matching the regular expression")
 	flag.String("test.bench", ".", "run benchmarks matching the regular expression")
 	flag.Bool("test.short", false, "tell long running tests to shorten their run time")
 	flag.Bool("test.benchmem", false, "include memory allocation statistics for comparison")
-	flag.Bool("best", false, "compare best times")
-	flag.Bool("changed", false, "show only benchmarks that have changed")
-	flag.Bool("mag", false, "sort benchmarks by magnitude of change")
+	flag.IntVar(&count, "count", 1, "number of times to run the benchmarks on each commit, for statistical comparison")
+	flag.BoolVar(&interleave, "interleave", false, "run one iteration per commit in round-robin order instead of all iterations of a commit at once, to spread out thermal/load drift across the whole session")
+	flag.StringVar(&tool, "tool", "auto", "comparison tool to use: benchstat, benchcmp, or auto (prefer benchstat if found on PATH)")
+	flag.Bool("best", false, "compare best times (benchcmp only)")
+	flag.Bool("changed", false, "show only benchmarks that have changed (benchcmp only)")
+	flag.Bool("mag", false, "sort benchmarks by magnitude of change (benchcmp only)")
+}
+
+// parseFlags parses the flags every file's init() has registered, validates
+// the arguments, and builds the benchmark/comparison commands. Must run
+// after every init() in the package has run, so it's called from main()
+// rather than from an init() itself.
+func parseFlags() {
 	flag.Parse()
 	// setup log
 	log.SetFlags(0)
-	if !*verbose {
+	if !verbose {
 		log.SetOutput(ioutil.Discard)
 	}
-	// check that there are enough commits to compare
-	commits = flag.Args()
-	if len(commits) < 2 {
+	// check that there is at least one commit, range, or refspec to expand
+	if len(flag.Args()) < 1 {
 		fmt.Fprintln(os.Stderr, "not enough commits to compare")
 		flag.Usage()
 		os.Exit(1)
 	}
 	// check that the necessary commands are present
-	for _, command := range []string{"go", "git", "benchcmp"} {
+	for _, command := range []string{"go", "git"} {
 		log.Println("checking for presence of", command)
 		if _, err := exec.LookPath(command); err != nil {
 			fmt.Fprintln(os.Stderr, "command not found:", command)
 			os.Exit(1)
 		}
 	}
+	// expand revision ranges (main~10..main) and refspecs (origin/main,
+	// HEAD@{yesterday}) into the individual commits to benchmark
+	commits = expandCommits(flag.Args())
+	if len(commits) < 2 {
+		fmt.Fprintln(os.Stderr, "not enough commits to compare")
+		flag.Usage()
+		os.Exit(1)
+	}
+	resolveFormat()
+	if format == "text" {
+		// only the text format actually shells out to the comparison tool,
+		// so don't require one to be on PATH for -format=json/md/csv
+		resolveTool()
+	}
+	if interleave && count > 1 && cacheDir != "" {
+		fmt.Fprintln(os.Stderr, "warning: -cache is not honored in -interleave mode, every run will re-benchmark")
+	}
 	// build commands from args
 	buildCommand(&benchcmd, "test.", []string{"run", "bench", "short", "benchmem"})
-	buildCommand(&comparecmd, "", []string{"best", "changed", "mag"})
-	comparecmd = append(comparecmd, "", "")[:len(comparecmd)] // make capacity for 2 more args
+	if count > 1 && !interleave {
+		// interleave mode runs one iteration per invocation instead, see benchInterleaved
+		benchcmd = append(benchcmd, fmt.Sprintf("-test.count=%d", count))
+	}
+	if tool == "benchcmp" {
+		buildCommand(&comparecmd, "", []string{"best", "changed", "mag"})
+		comparecmd = append(comparecmd, "", "")[:len(comparecmd)] // make capacity for 2 more args
+	}
 	log.Println("benchmark command:", benchcmd)
-	log.Println("benchcmp command:", append(comparecmd, "file1", "file2"))
+	log.Println("comparison tool:", tool)
+}
+
+// resolveTool decides which comparison tool to invoke based on the -tool flag,
+// auto-detecting benchstat on PATH and falling back to benchcmp when absent.
+func resolveTool() {
+	switch tool {
+	case "benchstat", "benchcmp":
+		// explicit choice, fall through to the presence check below
+	case "auto":
+		if _, err := exec.LookPath("benchstat"); err == nil {
+			tool = "benchstat"
+		} else {
+			tool = "benchcmp"
+		}
+	default:
+		fmt.Fprintln(os.Stderr, "unknown -tool:", tool, "(want benchstat, benchcmp, or auto)")
+		os.Exit(1)
+	}
+	if _, err := exec.LookPath(tool); err != nil {
+		fmt.Fprintln(os.Stderr, "command not found:", tool)
+		os.Exit(1)
+	}
+	if tool == "benchstat" {
+		comparecmd = []string{"benchstat"}
+	}
 }
 
 func main() {
 	defer catch()
+	parseFlags()
 	go handleInterrupt()
 	var err error
 	if outdir == "" {
@@ -77,22 +142,72 @@ func main() {
 		err = os.MkdirAll(outdir, 0666)
 		check("cannot create output directory:", err)
 	}
-	checkTreeClean()
-	defer func(ref string) { checkout(ref) }(getHeadRef())
-	defer killCurrentCmd()
+	worktrees := setupWorktrees(commits)
+	defer teardownWorktrees(worktrees)
+	var outfiles []string
+	if interleave && count > 1 {
+		outfiles = benchInterleaved(worktrees)
+	} else {
+		outfiles = make([]string, len(commits))
+		for i, commitish := range commits {
+			outfiles[i] = benchCommitCached(commitish, benchDir(worktrees[commitish]))
+		}
+	}
+	if format != "text" {
+		report(commits, outfiles)
+		return
+	}
+	if tool == "benchstat" {
+		// benchstat natively reports every file in one table, so there's no
+		// need to break N commits into N-1 pairwise comparisons.
+		compareAll(outfiles)
+	} else {
+		for i := range outfiles[:len(outfiles)-1] {
+			fmt.Println()
+			compare(outfiles[i], outfiles[i+1])
+		}
+	}
+}
+
+// benchCommit runs the go test benchmark inside workdir (a worktree checked
+// out to commitish), redirecting its output to a file under outdir, then
+// returns the name of the file.
+func benchCommit(commitish, workdir string) (name string) {
+	name, file := uniqueOutputFile(commitish)
+	defer func() { check("error closing bench output file", name, ":", file.Close()) }()
+	err := runCommand(timeout, workdir, file, benchcmd[0], benchcmd[1:]...)
+	check("cannot run benchmarks for", commitish, ":", err)
+	return
+}
+
+// benchInterleaved runs one benchmark iteration per commit in round-robin
+// order, `count` times through, appending each run's output to that commit's
+// result file. This samples every commit under similar machine conditions
+// instead of letting warmup, thermal throttling, or load drift bias later
+// commits in the list.
+func benchInterleaved(worktrees map[string]string) []string {
 	outfiles := make([]string, len(commits))
+	files := make([]*os.File, len(commits))
 	for i, commitish := range commits {
-		outfiles[i] = benchCommit(commitish)
+		outfiles[i], files[i] = uniqueOutputFile(commitish)
 	}
-	for i := range outfiles[:len(outfiles)-1] {
-		fmt.Println()
-		compare(outfiles[i], outfiles[i+1])
+	for round := 0; round < count; round++ {
+		for i, commitish := range commits {
+			log.Println("interleaved run", round+1, "of", count, "for", commitish)
+			err := runCommand(timeout, benchDir(worktrees[commitish]), files[i], benchcmd[0], benchcmd[1:]...)
+			check("cannot run benchmarks for", commitish, ":", err)
+		}
+	}
+	for i, file := range files {
+		check("error closing bench output file", outfiles[i], ":", file.Close())
 	}
+	return outfiles
 }
 
-// benchCommit checks out commitish and runs the go test benchmark on it, redirecting
-// its' output to a file under outdir, then returns the name of the file
-func benchCommit(commitish string) (name string) {
+// uniqueOutputFile creates a fresh, empty file under outdir to hold commitish's
+// benchmark output, falling back to a name derived from the full commit hash
+// if the commit-ish isn't a safe filename or a file with that name exists.
+func uniqueOutputFile(commitish string) (name string, file *os.File) {
 	name = filepath.Join(outdir, commitish)
 	log.Println("creating benchmark file:", name)
 	file, err := createNew(name)
@@ -110,31 +225,22 @@ func benchCommit(commitish string) (name string) {
 		}
 	}
 	check("cannot create temp file for benchmark results", name, ":", err)
-	defer func() { check("error closing bench output file", name, ":", file.Close()) }()
-	checkout(commitish)
-	log.Println("running benchmark...")
-	currentcmd = exec.Command(benchcmd[0], benchcmd[1:]...)
-	currentcmd.Stdout = file
-	err = currentcmd.Run()
-	check("cannot run benchmarks for", commitish, ":", err)
 	return
 }
 
 func compare(file1, file2 string) {
 	log.Println("comparing benchmark files:", file1, file2)
-	currentcmd = exec.Command(comparecmd[0], append(comparecmd[1:], file1, file2)...)
-	currentcmd.Stdout = os.Stdout
-	currentcmd.Stderr = os.Stderr
-	err := currentcmd.Run()
-	check("error running benchcmp:", err)
+	err := runCommand(timeout, "", os.Stdout, comparecmd[0], append(comparecmd[1:], file1, file2)...)
+	check("error running", comparecmd[0]+":", err)
 }
 
-func killCurrentCmd() {
-	c := currentcmd
-	if c != nil && c.ProcessState == nil && c.Process != nil {
-		log.Println("killing the currently running process:", c.Args)
-		c.Process.Kill()
-	}
+// compareAll passes every commit's result file to the comparison tool in a
+// single invocation, producing one table across all of them. Only benchstat
+// supports this; benchcmp is always called pairwise via compare.
+func compareAll(files []string) {
+	log.Println("comparing benchmark files:", files)
+	err := runCommand(timeout, "", os.Stdout, comparecmd[0], append(comparecmd[1:], files...)...)
+	check("error running", comparecmd[0]+":", err)
 }
 
 // createNew is os.Create but errors if the file already exists
@@ -142,37 +248,13 @@ func createNew(name string) (*os.File, error) {
 	return os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
 }
 
-// wrappers for git internals. these handle git commands internally and are never killed
+// wrappers for git internals
 func getCommitHash(commitish string) (hash string) {
-	log.Println("getting commit hash for", commitish)
-	cmd := exec.Command("git", "rev-parse", commitish+"^{commit}")
-	out, err := cmd.Output()
+	out, err := runCommandOutput(quickTimeout, "", "git", "rev-parse", commitish+"^{commit}")
 	check("cannot get hash for", commitish, ":", err)
 	return string(out)
 }
 
-func getHeadRef() string {
-	log.Println("getting HEAD ref")
-	cmd := exec.Command("git", "symbolic-ref", "--short", "HEAD", "--")
-	out, err := cmd.Output()
-	check("cannot get current HEAD ref:", err)
-	return strings.TrimSpace(string(out))
-}
-
-func checkTreeClean() {
-	log.Println("checking if the tree is clean")
-	cmd := exec.Command("git", "diff-index", "--quiet", "HEAD", "--")
-	err := cmd.Run()
-	check("working tree is dirty:", err)
-}
-
-func checkout(commitish string) {
-	log.Println("checking out", commitish)
-	cmd := exec.Command("git", "checkout", "--quiet", commitish, "--")
-	err := cmd.Run()
-	check("cannot checkout", commitish, ":", err)
-}
-
 // buildCommand	passes on the values of the flags listed in `flags` from those
 // passed to this command. Values are looked up with the name prefix+flags[n],
 // but appended to cmd with the name flags[n].
@@ -213,7 +295,7 @@ func handleInterrupt() {
 		interrupted = true
 		// change to verbose mode, also syncronizes with logging events
 		log.SetOutput(os.Stderr)
-		killCurrentCmd()
+		cancelRun()
 	}
 }
 
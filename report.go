@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var format string
+
+func init() {
+	flag.StringVar(&format, "format", "text", "output format: text, json, md, or csv. json/csv/md emit one row per commit+benchmark (N, ns/op, allocs/op, delta%, p-value); text runs the configured -tool as before")
+}
+
+// resolveFormat validates the -format flag, since an unknown value should be
+// a usage error, not something discovered after the benchmarks have run.
+func resolveFormat() {
+	switch format {
+	case "text", "json", "md", "csv":
+	default:
+		fmt.Fprintln(os.Stderr, "unknown -format:", format, "(want text, json, md, or csv)")
+		os.Exit(1)
+	}
+}
+
+// Row is one machine-readable line of a report: a single benchmark, on a
+// single commit, compared against the first commit in the list.
+type Row struct {
+	Commit      string  `json:"commit"`
+	Benchmark   string  `json:"benchmark"`
+	N           int     `json:"n"`
+	NsPerOp     float64 `json:"ns_per_op"`
+	AllocsPerOp float64 `json:"allocs_per_op,omitempty"`
+	DeltaPct    float64 `json:"delta_pct"`
+	PValue      float64 `json:"-"`
+}
+
+// MarshalJSON reports PValue as null instead of a raw NaN: encoding/json
+// can't marshal NaN/Inf floats at all, and mannWhitneyPValue returns NaN
+// whenever there isn't enough data to say anything (the tool's own default
+// of -count=1 non-interleaved always hits this), so the default struct
+// marshaling would make every -format=json report fail outright.
+func (r Row) MarshalJSON() ([]byte, error) {
+	type alias Row
+	aux := struct {
+		alias
+		PValue *float64 `json:"p_value"`
+	}{alias: alias(r)}
+	if !math.IsNaN(r.PValue) {
+		v := r.PValue
+		aux.PValue = &v
+	}
+	return json.Marshal(aux)
+}
+
+// benchRun is one `BenchmarkX ... ns/op` line from a go test -bench output file.
+type benchRun struct {
+	name        string
+	nsPerOp     float64
+	allocsPerOp float64
+	hasAllocs   bool
+}
+
+// report reads every commit's result file, aggregates it into rows comparing
+// each commit's benchmarks against the first commit's, and writes it to
+// stdout in the requested format.
+func report(commits []string, outfiles []string) {
+	perCommit := make([]map[string][]benchRun, len(outfiles))
+	for i, file := range outfiles {
+		perCommit[i] = parseBenchFile(file)
+	}
+	baseline := perCommit[0]
+	var rows []Row
+	for i, runs := range perCommit {
+		var names []string
+		for name := range runs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			rows = append(rows, rowFor(commits[i], name, runs[name], baseline[name]))
+		}
+	}
+	switch format {
+	case "json":
+		writeJSON(rows)
+	case "csv":
+		writeCSV(rows)
+	case "md":
+		writeMarkdown(rows)
+	default:
+		panic(checkedErr(fmt.Sprintf("unknown -format: %s", format)))
+	}
+}
+
+func rowFor(commit, name string, runs, baselineRuns []benchRun) Row {
+	r := Row{Commit: commit, Benchmark: name, N: len(runs)}
+	r.NsPerOp = meanNs(runs)
+	r.AllocsPerOp = meanAllocs(runs)
+	if baseMean := meanNs(baselineRuns); baseMean != 0 {
+		r.DeltaPct = (r.NsPerOp - baseMean) / baseMean * 100
+	}
+	r.PValue = mannWhitneyPValue(nsValues(baselineRuns), nsValues(runs))
+	return r
+}
+
+func nsValues(runs []benchRun) []float64 {
+	vals := make([]float64, len(runs))
+	for i, r := range runs {
+		vals[i] = r.nsPerOp
+	}
+	return vals
+}
+
+func meanNs(runs []benchRun) float64 {
+	if len(runs) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, r := range runs {
+		sum += r.nsPerOp
+	}
+	return sum / float64(len(runs))
+}
+
+func meanAllocs(runs []benchRun) float64 {
+	var sum float64
+	var n int
+	for _, r := range runs {
+		if r.hasAllocs {
+			sum += r.allocsPerOp
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+// parseBenchFile reads a go test -bench output file and groups its
+// "BenchmarkX ... ns/op" lines by benchmark name.
+func parseBenchFile(path string) map[string][]benchRun {
+	file, err := os.Open(path)
+	check("cannot open benchmark file", path, "for report:", err)
+	defer file.Close()
+	runs := make(map[string][]benchRun)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if run, ok := parseBenchLine(scanner.Text()); ok {
+			runs[run.name] = append(runs[run.name], run)
+		}
+	}
+	return runs
+}
+
+// parseBenchLine parses a single line of `go test -bench` output, e.g.:
+//
+//	BenchmarkFoo-8    1000000    123 ns/op    45 B/op    2 allocs/op
+func parseBenchLine(line string) (run benchRun, ok bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") {
+		return benchRun{}, false
+	}
+	run.name = fields[0]
+	if i := strings.LastIndex(run.name, "-"); i > 0 {
+		if _, err := strconv.Atoi(run.name[i+1:]); err == nil {
+			run.name = run.name[:i]
+		}
+	}
+	found := false
+	for i := 2; i+1 < len(fields); i += 2 {
+		value, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			continue
+		}
+		switch fields[i+1] {
+		case "ns/op":
+			run.nsPerOp = value
+			found = true
+		case "allocs/op":
+			run.allocsPerOp = value
+			run.hasAllocs = true
+		}
+	}
+	return run, found
+}
+
+// mannWhitneyPValue computes a normal-approximation two-sided p-value for
+// the Mann-Whitney U test between two samples. Returns NaN if there isn't
+// enough data to say anything (fewer than 2 samples in either group).
+func mannWhitneyPValue(a, b []float64) float64 {
+	if len(a) < 2 || len(b) < 2 {
+		return math.NaN()
+	}
+	type tagged struct {
+		value float64
+		group int
+	}
+	all := make([]tagged, 0, len(a)+len(b))
+	for _, v := range a {
+		all = append(all, tagged{v, 0})
+	}
+	for _, v := range b {
+		all = append(all, tagged{v, 1})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].value < all[j].value })
+	ranks := make([]float64, len(all))
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].value == all[i].value {
+			j++
+		}
+		avgRank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			ranks[k] = avgRank
+		}
+		i = j
+	}
+	var rankSumA float64
+	for i, t := range all {
+		if t.group == 0 {
+			rankSumA += ranks[i]
+		}
+	}
+	n1, n2 := float64(len(a)), float64(len(b))
+	u1 := rankSumA - n1*(n1+1)/2
+	u := math.Min(u1, n1*n2-u1)
+	meanU := n1 * n2 / 2
+	sigmaU := math.Sqrt(n1 * n2 * (n1 + n2 + 1) / 12)
+	if sigmaU == 0 {
+		return math.NaN()
+	}
+	z := (u - meanU) / sigmaU
+	return 2 * (1 - normalCDF(math.Abs(z)))
+}
+
+func normalCDF(z float64) float64 {
+	return 0.5 * (1 + math.Erf(z/math.Sqrt2))
+}
+
+func writeJSON(rows []Row) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "\t")
+	check("cannot write json report:", enc.Encode(rows))
+}
+
+func writeCSV(rows []Row) {
+	fmt.Println("commit,benchmark,n,ns_per_op,allocs_per_op,delta_pct,p_value")
+	for _, r := range rows {
+		fmt.Printf("%s,%s,%d,%g,%g,%g,%s\n", r.Commit, r.Benchmark, r.N, r.NsPerOp, r.AllocsPerOp, r.DeltaPct, pValueString(r.PValue))
+	}
+}
+
+func writeMarkdown(rows []Row) {
+	fmt.Println("| commit | benchmark | n | ns/op | allocs/op | delta % | p-value |")
+	fmt.Println("|---|---|---|---|---|---|---|")
+	for _, r := range rows {
+		fmt.Printf("| %s | %s | %d | %g | %g | %+.2f%% | %s |\n", r.Commit, r.Benchmark, r.N, r.NsPerOp, r.AllocsPerOp, r.DeltaPct, pValueString(r.PValue))
+	}
+}
+
+func pValueString(p float64) string {
+	if math.IsNaN(p) {
+		return "n/a"
+	}
+	return strconv.FormatFloat(p, 'g', 4, 64)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+)
+
+// expandCommits turns the raw positional arguments into the list of
+// commits to benchmark. A revision range like "main~10..main" or
+// "v1.0...v1.1" is expanded via `git rev-list` into its individual commits;
+// anything else (a plain commit-ish or a refspec like "origin/main" or
+// "HEAD@{yesterday}") is passed through unchanged, since every git command
+// this tool runs already accepts those directly.
+func expandCommits(args []string) []string {
+	var commits []string
+	for _, arg := range args {
+		if strings.Contains(arg, "..") {
+			commits = append(commits, revList(arg)...)
+		} else {
+			commits = append(commits, arg)
+		}
+	}
+	return commits
+}
+
+// revList expands a revision range into the commit hashes it contains,
+// oldest first.
+func revList(rangeExpr string) []string {
+	out, err := runCommandOutput(quickTimeout, "", "git", "rev-list", "--reverse", rangeExpr, "--")
+	check("cannot expand revision range", rangeExpr, ":", err)
+	var hashes []string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if line != "" {
+			hashes = append(hashes, line)
+		}
+	}
+	return hashes
+}
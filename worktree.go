@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	invocationSubdirOnce sync.Once
+	invocationSubdirVal  string
+)
+
+// invocationSubdir is the path from the repo root down to the directory
+// gitbenchcmp was invoked from, e.g. "pkg/foo" when run from inside that
+// package of a multi-package repo, or "" when run from the repo root.
+func invocationSubdir() string {
+	invocationSubdirOnce.Do(func() {
+		out, err := runCommandOutput(quickTimeout, "", "git", "rev-parse", "--show-prefix")
+		check("cannot determine invocation subdirectory:", err)
+		invocationSubdirVal = strings.TrimRight(strings.TrimSpace(string(out)), "/")
+	})
+	return invocationSubdirVal
+}
+
+// benchDir returns the directory benchmarks for a worktree rooted at root
+// should run in: root itself, joined with invocationSubdir so that running
+// gitbenchcmp from inside a package subdirectory benchmarks that package in
+// every worktree, not the repo root. Worktree management (gitWorktreeAdd,
+// removeWorktree) still operates on root directly.
+func benchDir(root string) string {
+	return filepath.Join(root, invocationSubdir())
+}
+
+var parallel int
+
+func init() {
+	flag.IntVar(&parallel, "parallel", 1, "number of commits to build worktrees for concurrently. benchmarks are always run one at a time to avoid contention")
+}
+
+// setupWorktrees creates a `git worktree` for each commit under
+// outdir/worktrees, up to -parallel at a time, and returns the working
+// directory for each commit-ish. Using worktrees instead of checking out in
+// place means the main working tree never has to be clean, and commits can
+// be built concurrently even though their benchmarks still run serially.
+//
+// addWorktree panics (via check) on failure, and that happens in a goroutine
+// here, so it's recovered per-goroutine rather than left to crash the whole
+// process: an unrecovered goroutine panic would skip main's deferred
+// teardownWorktrees and leave every worktree created so far registered with
+// git but never cleaned up. Instead, any worktrees that did succeed are torn
+// down here before the panic is re-raised on the calling goroutine, where
+// catch() can report it and exit normally.
+func setupWorktrees(commits []string) map[string]string {
+	n := parallel
+	if n < 1 {
+		n = 1
+	}
+	dirs := make(map[string]string, len(commits))
+	var mu sync.Mutex
+	var firstPanic interface{}
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	for _, commitish := range commits {
+		commitish := commitish
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			defer func() {
+				if r := recover(); r != nil {
+					mu.Lock()
+					if firstPanic == nil {
+						firstPanic = r
+					}
+					mu.Unlock()
+				}
+			}()
+			dir := addWorktree(commitish)
+			mu.Lock()
+			dirs[commitish] = dir
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if firstPanic != nil {
+		teardownWorktrees(dirs)
+		panic(firstPanic)
+	}
+	return dirs
+}
+
+// teardownWorktrees removes every worktree created by setupWorktrees.
+func teardownWorktrees(dirs map[string]string) {
+	for commitish, dir := range dirs {
+		log.Println("removing worktree for", commitish, ":", dir)
+		removeWorktree(dir)
+	}
+}
+
+// addWorktree creates a worktree for commitish under outdir/worktrees and
+// returns its path, disambiguating against name collisions the same way
+// uniqueOutputFile does for result files.
+func addWorktree(commitish string) string {
+	base := filepath.Join(outdir, "worktrees", sanitizeName(commitish))
+	dir := base
+	err := gitWorktreeAdd(dir, commitish)
+	if err != nil {
+		hash := strings.TrimSpace(getCommitHash(commitish))
+		dir = base + "-" + hash[:12]
+		err = gitWorktreeAdd(dir, commitish)
+	}
+	check("cannot create worktree for", commitish, ":", err)
+	return dir
+}
+
+func gitWorktreeAdd(dir, commitish string) error {
+	return runCommand(quickTimeout, "", nil, "git", "worktree", "add", "--quiet", "--detach", dir, commitish, "--")
+}
+
+func removeWorktree(dir string) {
+	check("cannot remove worktree", dir, ":", runCommand(quickTimeout, "", nil, "git", "worktree", "remove", "--force", dir))
+}
+
+// sanitizeName turns a commit-ish into something safe to use as a single
+// path component, e.g. "origin/main" -> "origin_main".
+func sanitizeName(commitish string) string {
+	return strings.NewReplacer("/", "_", ":", "_", "@", "_", "{", "_", "}", "_").Replace(commitish)
+}